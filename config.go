@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/gofly/iperf_exporter/iperf3"
+)
+
+// TargetConfig describes a single iperf3 server to probe and the
+// parameters to run against it.
+//
+// MSS and Window are accepted for config-file compatibility but are not
+// yet applied by the native iperf3 client.
+type TargetConfig struct {
+	Address   string            `yaml:"address"`
+	Port      string            `yaml:"port"`
+	Protocol  string            `yaml:"protocol"`
+	Bandwidth string            `yaml:"bandwidth"`
+	Duration  string            `yaml:"duration"`
+	Parallel  int               `yaml:"parallel"`
+	Reverse   bool              `yaml:"reverse"`
+	Length    int               `yaml:"length"`
+	MSS       int               `yaml:"mss"`
+	Window    string            `yaml:"window"`
+	Labels    map[string]string `yaml:"labels"`
+}
+
+// iperf3Config converts t into the iperf3.Config that iperf3.Run
+// expects.
+func (t TargetConfig) iperf3Config() (iperf3.Config, error) {
+	duration, err := time.ParseDuration(t.Duration)
+	if err != nil {
+		return iperf3.Config{}, fmt.Errorf("parse duration %q: %w", t.Duration, err)
+	}
+	bandwidth, err := parseBandwidth(t.Bandwidth)
+	if err != nil {
+		return iperf3.Config{}, fmt.Errorf("parse bandwidth %q: %w", t.Bandwidth, err)
+	}
+	return iperf3.Config{
+		Address:   t.Address,
+		Port:      t.Port,
+		UDP:       t.Protocol == "udp",
+		Reverse:   t.Reverse,
+		Parallel:  t.Parallel,
+		Duration:  duration,
+		Bandwidth: bandwidth,
+		Len:       t.Length,
+	}, nil
+}
+
+// parseBandwidth parses an iperf3-style bandwidth string such as "10M"
+// or "1G" into bits/sec. An empty string means unlimited (0).
+func parseBandwidth(s string) (uint64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	multiplier := uint64(1)
+	switch suffix := s[len(s)-1]; suffix {
+	case 'k', 'K':
+		multiplier = 1_000
+		s = s[:len(s)-1]
+	case 'm', 'M':
+		multiplier = 1_000_000
+		s = s[:len(s)-1]
+	case 'g', 'G':
+		multiplier = 1_000_000_000
+		s = s[:len(s)-1]
+	}
+	value, err := strconv.ParseUint(strings.TrimSpace(s), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return value * multiplier, nil
+}
+
+// Module describes the parameters for a single probe run, selected by
+// name from the /probe?module= query parameter.
+//
+// ConnectTimeout is accepted for config-file compatibility with
+// blackbox_exporter-style modules but is not yet applied separately
+// from the overall /probe deadline.
+type Module struct {
+	Protocol       string `yaml:"protocol"`
+	Bandwidth      string `yaml:"bandwidth"`
+	Duration       string `yaml:"duration"`
+	Streams        int    `yaml:"streams"`
+	Reverse        bool   `yaml:"reverse"`
+	UDP            bool   `yaml:"udp"`
+	ConnectTimeout string `yaml:"connect_timeout"`
+}
+
+// targetConfig converts m plus a target's address/port into the
+// TargetConfig iperf3Config expects, applying the same defaults as
+// LoadConfig does for background-loop targets.
+func (m Module) targetConfig(address, port string) TargetConfig {
+	protocol := m.Protocol
+	if m.UDP {
+		protocol = "udp"
+	}
+	if protocol == "" {
+		protocol = "tcp"
+	}
+	duration := m.Duration
+	if duration == "" {
+		duration = "10s"
+	}
+	parallel := m.Streams
+	if parallel <= 0 {
+		parallel = 1
+	}
+	return TargetConfig{
+		Address:   address,
+		Port:      port,
+		Protocol:  protocol,
+		Bandwidth: m.Bandwidth,
+		Duration:  duration,
+		Parallel:  parallel,
+		Reverse:   m.Reverse,
+	}
+}
+
+// Config is the top-level structure of the `-config.file` document. It
+// holds both the background-loop targets and the named modules used by
+// the /probe endpoint; a deployment can use either section, or both.
+type Config struct {
+	// Concurrency bounds how many probes may run at the same time
+	// across all targets.
+	Concurrency int               `yaml:"concurrency"`
+	Targets     []TargetConfig    `yaml:"targets"`
+	Modules     map[string]Module `yaml:"modules"`
+
+	// sem bounds concurrent iperf3 runs across both the background
+	// loop and /probe, since two clients can't share the same server
+	// socket. initSemaphore must be called once before acquire/release
+	// are used.
+	sem chan struct{}
+}
+
+// initSemaphore sizes c's shared run pool from c.Concurrency. It must
+// be called once after Concurrency is finalized and before the
+// background loop or /probe start calling acquire/release.
+func (c *Config) initSemaphore() {
+	if c.Concurrency <= 0 {
+		c.Concurrency = 1
+	}
+	c.sem = make(chan struct{}, c.Concurrency)
+}
+
+// acquire blocks until a run slot is free.
+func (c *Config) acquire() { c.sem <- struct{}{} }
+
+// release returns a run slot acquired via acquire.
+func (c *Config) release() { <-c.sem }
+
+// LoadConfig reads and validates the exporter configuration at path,
+// filling in defaults for any field left unset.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config file: %w", err)
+	}
+	cfg := &Config{}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("parse config file: %w", err)
+	}
+	if len(cfg.Targets) == 0 && len(cfg.Modules) == 0 {
+		return nil, fmt.Errorf("config file defines no targets and no modules")
+	}
+	for i := range cfg.Targets {
+		t := &cfg.Targets[i]
+		if t.Address == "" {
+			return nil, fmt.Errorf("target %d: address is required", i)
+		}
+		if t.Port == "" {
+			t.Port = "5201"
+		}
+		if t.Protocol == "" {
+			t.Protocol = "tcp"
+		}
+		if t.Duration == "" {
+			t.Duration = "10s"
+		}
+		if t.Parallel <= 0 {
+			t.Parallel = 1
+		}
+		if t.MSS != 0 || t.Window != "" {
+			log.Printf("[WARN] target %d (%s): mss and window are not applied by the native iperf3 client and will be ignored", i, t.Address)
+		}
+	}
+	cfg.initSemaphore()
+	return cfg, nil
+}
+
+// labelKeys returns the sorted, de-duplicated set of custom label names
+// used across all targets. Prometheus requires every series in a
+// GaugeVec to carry the same label set, so targets missing one of these
+// keys get an empty value for it.
+func (c *Config) labelKeys() []string {
+	seen := map[string]struct{}{}
+	for _, t := range c.Targets {
+		for k := range t.Labels {
+			seen[k] = struct{}{}
+		}
+	}
+	keys := make([]string, 0, len(seen))
+	for k := range seen {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseBandwidth(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    uint64
+		wantErr bool
+	}{
+		{in: "", want: 0},
+		{in: "1000", want: 1000},
+		{in: "10K", want: 10_000},
+		{in: "10k", want: 10_000},
+		{in: "10M", want: 10_000_000},
+		{in: "1G", want: 1_000_000_000},
+		{in: " 5M", want: 5_000_000},
+		{in: "not-a-number", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseBandwidth(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseBandwidth(%q): want error, got none", tt.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseBandwidth(%q): unexpected error: %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseBandwidth(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestConfigLabelKeys(t *testing.T) {
+	cfg := &Config{
+		Targets: []TargetConfig{
+			{Address: "a", Labels: map[string]string{"region": "us", "env": "prod"}},
+			{Address: "b", Labels: map[string]string{"region": "eu"}},
+			{Address: "c"},
+		},
+	}
+	got := cfg.labelKeys()
+	want := []string{"env", "region"}
+	if len(got) != len(want) {
+		t.Fatalf("labelKeys() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("labelKeys() = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestConfigLabelKeysEmpty(t *testing.T) {
+	cfg := &Config{Targets: []TargetConfig{{Address: "a"}}}
+	if got := cfg.labelKeys(); len(got) != 0 {
+		t.Errorf("labelKeys() = %v, want empty", got)
+	}
+}
+
+func TestConfigSemaphoreBoundsConcurrency(t *testing.T) {
+	cfg := &Config{Concurrency: 2}
+	cfg.initSemaphore()
+
+	cfg.acquire()
+	cfg.acquire()
+
+	acquired := make(chan struct{})
+	go func() {
+		cfg.acquire()
+		close(acquired)
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("acquire() succeeded past the concurrency limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cfg.release()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("acquire() did not unblock after release()")
+	}
+	cfg.release()
+	cfg.release()
+}
+
+func TestConfigInitSemaphoreDefaultsConcurrency(t *testing.T) {
+	cfg := &Config{}
+	cfg.initSemaphore()
+	if cfg.Concurrency != 1 {
+		t.Errorf("Concurrency = %d, want 1", cfg.Concurrency)
+	}
+	cfg.acquire()
+	cfg.release()
+}
@@ -0,0 +1,74 @@
+// Package iperf3 implements enough of the iperf3 control and data
+// protocol (see iperf3's src/iperf_api.c and src/iperf_tcp_api.c) to run
+// a test against a standard iperf3 server without shelling out to the
+// iperf3 binary. It covers the common case of a single unidirectional
+// TCP or UDP test, optionally reversed and with multiple parallel
+// streams; bidirectional tests and some of the more exotic flags
+// (zerocopy, pacing, JSON streaming output) are out of scope. UDP
+// datagrams use iperf3's own wire format (see udpHeaderSize), with one
+// gap: this client doesn't send iperf3's final-packet marker, so loss
+// counting relies on the test deadline instead.
+package iperf3
+
+import "time"
+
+// Config describes a single test run against a server's control port.
+type Config struct {
+	Address string
+	Port    string
+
+	UDP      bool
+	Reverse  bool
+	Parallel int
+
+	// Duration is how long the test runs once streams are established.
+	Duration time.Duration
+	// Interval is how often IntervalSamples are reported; it defaults
+	// to one second if zero.
+	Interval time.Duration
+	// Bandwidth caps the send rate in bits/sec; required for UDP,
+	// ignored for TCP (0 means unlimited).
+	Bandwidth uint64
+	// Len is the read/write buffer size in bytes; it defaults to 128KB
+	// for TCP and 1460 bytes for UDP if zero.
+	Len int
+}
+
+// IntervalSample is one reporting interval's worth of throughput for a
+// single direction, equivalent to one row of iperf3's interval report.
+type IntervalSample struct {
+	Start         float64
+	End           float64
+	Seconds       float64
+	Bytes         int64
+	BitsPerSecond float64
+}
+
+// Result is the parsed outcome of a Run.
+type Result struct {
+	SentBitsPerSecond     float64
+	ReceivedBitsPerSecond float64
+	Retransmits           int64
+
+	// UDP-only fields, populated from whichever side of the test
+	// received the datagrams.
+	JitterMs    float64
+	LostPackets int64
+	Packets     int64
+	LostPercent float64
+
+	SentIntervals     []IntervalSample
+	ReceivedIntervals []IntervalSample
+
+	// LocalCPU and RemoteCPU are each side's CPU utilization during the
+	// test, as a percentage of wall-clock time.
+	LocalCPU  CPUUtilization
+	RemoteCPU CPUUtilization
+}
+
+// CPUUtilization mirrors one side of iperf3's cpu_util_percent report.
+type CPUUtilization struct {
+	User   float64
+	System float64
+	Total  float64
+}
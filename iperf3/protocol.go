@@ -0,0 +1,471 @@
+package iperf3
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Control-channel states, as defined in iperf3's src/iperf.h.
+const (
+	stateTestStart      int8 = 1
+	stateTestRunning    int8 = 2
+	stateTestEnd        int8 = 4
+	stateParamExchange  int8 = 9
+	stateCreateStreams  int8 = 10
+	stateExchangeResult int8 = 13
+	stateDisplayResults int8 = 14
+	stateIperfDone      int8 = 16
+	stateAccessDenied   int8 = -1
+	stateServerError    int8 = -2
+)
+
+const cookieSize = 37
+
+// newCookie generates the random identifier the control connection and
+// every data stream connection present to let the server associate them
+// with the same test.
+func newCookie() (string, error) {
+	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ234567"
+	raw := make([]byte, cookieSize-1)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("generate cookie: %w", err)
+	}
+	cookie := make([]byte, cookieSize-1)
+	for i, b := range raw {
+		cookie[i] = charset[int(b)%len(charset)]
+	}
+	return string(cookie), nil
+}
+
+// testParams is the JSON payload iperf3 exchanges during
+// PARAM_EXCHANGE; field names match the keys the reference
+// implementation uses on the wire.
+type testParams struct {
+	TCP       int     `json:"tcp,omitempty"`
+	UDP       int     `json:"udp,omitempty"`
+	Reverse   int     `json:"reverse,omitempty"`
+	Omit      int     `json:"omit"`
+	Time      int     `json:"time"`
+	Parallel  int     `json:"parallel"`
+	Len       int     `json:"len,omitempty"`
+	Bandwidth uint64  `json:"bandwidth,omitempty"`
+	Interval  float64 `json:"interval,omitempty"`
+}
+
+// sideResult is the JSON payload exchanged during EXCHANGE_RESULTS,
+// trimmed to the fields this client produces and consumes.
+type sideResult struct {
+	Bytes       int64          `json:"bytes"`
+	Retransmits int64          `json:"retransmits"`
+	JitterMs    float64        `json:"jitter_ms"`
+	LostPackets int64          `json:"lost_packets"`
+	Packets     int64          `json:"packets"`
+	Seconds     float64        `json:"seconds"`
+	Intervals   []intervalWire `json:"intervals,omitempty"`
+	CPUUser     float64        `json:"cpu_user_percent"`
+	CPUSystem   float64        `json:"cpu_system_percent"`
+	CPUTotal    float64        `json:"cpu_total_percent"`
+}
+
+// intervalWire is one row of a side's interval report, equivalent to an
+// entry of iperf3's "intervals[].sum" object.
+type intervalWire struct {
+	Seconds       float64 `json:"seconds"`
+	Bytes         int64   `json:"bytes"`
+	BitsPerSecond float64 `json:"bits_per_second"`
+}
+
+func readState(r io.Reader) (int8, error) {
+	var b [1]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return 0, err
+	}
+	return int8(b[0]), nil
+}
+
+func writeState(w io.Writer, s int8) error {
+	_, err := w.Write([]byte{byte(s)})
+	return err
+}
+
+func expectState(r io.Reader, want int8) error {
+	got, err := readState(r)
+	if err != nil {
+		return fmt.Errorf("read control state: %w", err)
+	}
+	switch got {
+	case stateAccessDenied:
+		return errors.New("server denied the test")
+	case stateServerError:
+		return errors.New("server reported an error")
+	}
+	if got != want {
+		return fmt.Errorf("unexpected control state %d, want %d", got, want)
+	}
+	return nil
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(payload)))
+	if _, err := w.Write(size[:]); err != nil {
+		return err
+	}
+	_, err = w.Write(payload)
+	return err
+}
+
+func readJSON(r io.Reader, v interface{}) error {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return err
+	}
+	buf := make([]byte, binary.BigEndian.Uint32(size[:]))
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return err
+	}
+	return json.Unmarshal(buf, v)
+}
+
+// Run executes a single iperf3 test against cfg.Address:cfg.Port. It
+// drives the control connection through the cookie handshake and the
+// PARAM_EXCHANGE / CREATE_STREAMS / TEST_START / TEST_RUNNING /
+// TEST_END / EXCHANGE_RESULTS / DISPLAY_RESULTS state machine, opening
+// cfg.Parallel data connections in between. The run is bound to ctx, so
+// cancelling it (e.g. via context.WithTimeout) aborts the test instead
+// of blocking until the server gives up.
+func Run(ctx context.Context, cfg Config) (*Result, error) {
+	if cfg.Parallel <= 0 {
+		cfg.Parallel = 1
+	}
+	network := "tcp"
+	if cfg.UDP {
+		network = "udp"
+	}
+	addr := net.JoinHostPort(cfg.Address, cfg.Port)
+
+	var dialer net.Dialer
+	ctrl, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("dial control connection: %w", err)
+	}
+	defer ctrl.Close()
+	if dl, ok := ctx.Deadline(); ok {
+		ctrl.SetDeadline(dl)
+	}
+
+	cookie, err := newCookie()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(ctrl, cookie+"\x00"); err != nil {
+		return nil, fmt.Errorf("send cookie: %w", err)
+	}
+
+	if err := expectState(ctrl, stateParamExchange); err != nil {
+		return nil, err
+	}
+	params := testParams{
+		Omit:     0,
+		Time:     int(cfg.Duration / time.Second),
+		Parallel: cfg.Parallel,
+		Len:      cfg.Len,
+	}
+	if cfg.UDP {
+		params.UDP = 1
+		params.Bandwidth = cfg.Bandwidth
+	} else {
+		params.TCP = 1
+	}
+	if cfg.Reverse {
+		params.Reverse = 1
+	}
+	if err := writeJSON(ctrl, params); err != nil {
+		return nil, fmt.Errorf("send test params: %w", err)
+	}
+
+	if err := expectState(ctrl, stateCreateStreams); err != nil {
+		return nil, err
+	}
+	streams := make([]net.Conn, 0, cfg.Parallel)
+	defer func() {
+		for _, s := range streams {
+			s.Close()
+		}
+	}()
+	for i := 0; i < cfg.Parallel; i++ {
+		sc, err := dialer.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, fmt.Errorf("open data stream %d: %w", i, err)
+		}
+		if _, err := io.WriteString(sc, cookie+"\x00"); err != nil {
+			return nil, fmt.Errorf("send cookie on data stream %d: %w", i, err)
+		}
+		streams = append(streams, sc)
+	}
+
+	if err := expectState(ctrl, stateTestStart); err != nil {
+		return nil, err
+	}
+	if err := expectState(ctrl, stateTestRunning); err != nil {
+		return nil, err
+	}
+
+	cpuBefore := cpuTimes()
+	local := runStreams(ctx, cfg, streams)
+	local.CPUUser, local.CPUSystem, local.CPUTotal = cpuPercent(cpuBefore, cpuTimes(), time.Duration(local.Seconds*float64(time.Second)))
+
+	if err := writeState(ctrl, stateTestEnd); err != nil {
+		return nil, fmt.Errorf("send test end: %w", err)
+	}
+	if err := expectState(ctrl, stateExchangeResult); err != nil {
+		return nil, err
+	}
+	if err := writeJSON(ctrl, local); err != nil {
+		return nil, fmt.Errorf("send local results: %w", err)
+	}
+	var remote sideResult
+	if err := readJSON(ctrl, &remote); err != nil {
+		return nil, fmt.Errorf("read remote results: %w", err)
+	}
+	if err := expectState(ctrl, stateDisplayResults); err != nil {
+		return nil, err
+	}
+	_ = writeState(ctrl, stateIperfDone)
+
+	return mergeResult(cfg, local, remote), nil
+}
+
+// runStreams drives the data connections for cfg.Duration: in the
+// default direction the client sends and the server receives, so the
+// server's EXCHANGE_RESULTS report carries the authoritative received
+// throughput/jitter/loss; in reverse mode the roles (and therefore
+// which side's report is authoritative for those fields) swap. For UDP,
+// the sending side paces writes to cfg.Bandwidth and stamps every
+// datagram with a sequence number and send time, and the receiving side
+// (reverse mode, since that's when this client receives) turns those
+// back into real jitter and loss figures instead of leaving them at
+// zero. Every cfg.Interval it snapshots the combined byte counter
+// across all streams into an IntervalSample, mirroring iperf3's own
+// interval reports.
+func runStreams(ctx context.Context, cfg Config, streams []net.Conn) sideResult {
+	bufSize := cfg.Len
+	if bufSize <= 0 {
+		if cfg.UDP {
+			bufSize = 1460
+		} else {
+			bufSize = 128 * 1024
+		}
+	}
+	if cfg.UDP && bufSize < udpHeaderSize {
+		bufSize = udpHeaderSize
+	}
+	interval := cfg.Interval
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	start := time.Now()
+	runCtx, cancel := context.WithDeadline(ctx, start.Add(cfg.Duration))
+	defer cancel()
+
+	var counter atomic.Int64
+	var stats udpStats
+	var retransmits atomic.Int64
+	bandwidthPerStream := cfg.Bandwidth / uint64(cfg.Parallel)
+	var wg sync.WaitGroup
+	for _, s := range streams {
+		wg.Add(1)
+		go func(s net.Conn) {
+			defer wg.Done()
+			switch {
+			case cfg.UDP && cfg.Reverse:
+				udpReceive(runCtx, s, bufSize, &counter, &stats)
+			case cfg.UDP:
+				udpSend(runCtx, s, bufSize, bandwidthPerStream, &counter)
+			default:
+				buf := make([]byte, bufSize)
+				if cfg.Reverse {
+					copyUntilDone(runCtx, io.Discard, s, buf, &counter)
+				} else {
+					copyUntilDone(runCtx, s, zeroReader{}, buf, &counter)
+					// Retransmits is meaningful on whichever side sends;
+					// read it from our own socket now, while it's still
+					// open, rather than trust the passive receiver to
+					// report something for a stat it never sees.
+					retransmits.Add(tcpRetransmits(s))
+				}
+			}
+		}(s)
+	}
+
+	var intervals []intervalWire
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var last int64
+	lastAt := start
+	for {
+		select {
+		case now := <-ticker.C:
+			n := counter.Load()
+			elapsed := now.Sub(lastAt).Seconds()
+			intervals = append(intervals, intervalWire{
+				Seconds:       elapsed,
+				Bytes:         n - last,
+				BitsPerSecond: bitsPerSecond(n-last, elapsed),
+			})
+			last, lastAt = n, now
+		case <-runCtx.Done():
+			wg.Wait()
+			result := sideResult{
+				Bytes:     counter.Load(),
+				Seconds:   time.Since(start).Seconds(),
+				Intervals: intervals,
+			}
+			if cfg.UDP && cfg.Reverse {
+				result.JitterMs, result.LostPackets, result.Packets = stats.result()
+			}
+			if !cfg.UDP && !cfg.Reverse {
+				result.Retransmits = retransmits.Load()
+			}
+			return result
+		}
+	}
+}
+
+// zeroReader is an io.Reader of zero bytes, used as the send-side
+// payload source since iperf3 throughput tests don't care about
+// content.
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+// copyUntilDone streams from src to dst in bufSize chunks until ctx is
+// done, adding every byte moved to counter so a concurrent reporter can
+// sample interval throughput.
+func copyUntilDone(ctx context.Context, dst io.Writer, src io.Reader, buf []byte, counter *atomic.Int64) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+		n, err := src.Read(buf)
+		if n > 0 {
+			if _, werr := dst.Write(buf[:n]); werr != nil {
+				return
+			}
+			counter.Add(int64(n))
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// mergeResult combines what this client measured locally with what the
+// server reported, preferring the receiver's figures for
+// throughput/jitter/loss since those can only be measured on the
+// receiving end. Retransmits is the opposite: it can only be measured
+// on the sender, so it comes from whichever side's sideResult
+// corresponds to the sender for cfg.Reverse.
+func mergeResult(cfg Config, local, remote sideResult) *Result {
+	result := &Result{}
+	seconds := local.Seconds
+	if seconds <= 0 {
+		seconds = cfg.Duration.Seconds()
+	}
+
+	if cfg.Reverse {
+		result.ReceivedBitsPerSecond = bitsPerSecond(local.Bytes, seconds)
+		result.SentBitsPerSecond = bitsPerSecond(remote.Bytes, seconds)
+		result.Retransmits = remote.Retransmits
+		result.JitterMs = local.JitterMs
+		result.LostPackets = local.LostPackets
+		result.Packets = local.Packets
+		result.ReceivedIntervals = toIntervalSamples(local.Intervals)
+		result.SentIntervals = toIntervalSamples(remote.Intervals)
+	} else {
+		result.SentBitsPerSecond = bitsPerSecond(local.Bytes, seconds)
+		result.ReceivedBitsPerSecond = bitsPerSecond(remote.Bytes, seconds)
+		result.Retransmits = local.Retransmits
+		result.JitterMs = remote.JitterMs
+		result.LostPackets = remote.LostPackets
+		result.Packets = remote.Packets
+		result.SentIntervals = toIntervalSamples(local.Intervals)
+		result.ReceivedIntervals = toIntervalSamples(remote.Intervals)
+	}
+	if result.Packets > 0 {
+		result.LostPercent = float64(result.LostPackets) / float64(result.Packets) * 100
+	}
+	result.LocalCPU = CPUUtilization{User: local.CPUUser, System: local.CPUSystem, Total: local.CPUTotal}
+	result.RemoteCPU = CPUUtilization{User: remote.CPUUser, System: remote.CPUSystem, Total: remote.CPUTotal}
+	return result
+}
+
+func toIntervalSamples(intervals []intervalWire) []IntervalSample {
+	samples := make([]IntervalSample, len(intervals))
+	var elapsed float64
+	for i, iv := range intervals {
+		samples[i] = IntervalSample{
+			Start:         elapsed,
+			End:           elapsed + iv.Seconds,
+			Seconds:       iv.Seconds,
+			Bytes:         iv.Bytes,
+			BitsPerSecond: iv.BitsPerSecond,
+		}
+		elapsed += iv.Seconds
+	}
+	return samples
+}
+
+func bitsPerSecond(bytes int64, seconds float64) float64 {
+	if seconds <= 0 {
+		return 0
+	}
+	return float64(bytes) * 8 / seconds
+}
+
+// cpuTimes snapshots this process's user (index 0) and system (index 1)
+// CPU time.
+func cpuTimes() [2]time.Duration {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return [2]time.Duration{}
+	}
+	return [2]time.Duration{time.Duration(ru.Utime.Nano()), time.Duration(ru.Stime.Nano())}
+}
+
+// cpuPercent turns a before/after pair of cpuTimes snapshots into the
+// percentage of wall-clock time spent in user/system/total CPU, the
+// same figures iperf3 reports as cpu_util_percent.
+func cpuPercent(before [2]time.Duration, after [2]time.Duration, wall time.Duration) (user, system, total float64) {
+	if wall <= 0 {
+		return 0, 0, 0
+	}
+	userDelta := after[0] - before[0]
+	systemDelta := after[1] - before[1]
+	user = float64(userDelta) / float64(wall) * 100
+	system = float64(systemDelta) / float64(wall) * 100
+	total = user + system
+	return user, system, total
+}
@@ -0,0 +1,91 @@
+package iperf3
+
+import (
+	"testing"
+	"time"
+)
+
+func TestToIntervalSamples(t *testing.T) {
+	intervals := []intervalWire{
+		{Seconds: 1, Bytes: 1000, BitsPerSecond: 8000},
+		{Seconds: 1, Bytes: 2000, BitsPerSecond: 16000},
+	}
+	samples := toIntervalSamples(intervals)
+	if len(samples) != 2 {
+		t.Fatalf("len(samples) = %d, want 2", len(samples))
+	}
+	if samples[0].Start != 0 || samples[0].End != 1 {
+		t.Errorf("samples[0] = %+v, want Start=0 End=1", samples[0])
+	}
+	if samples[1].Start != 1 || samples[1].End != 2 {
+		t.Errorf("samples[1] = %+v, want Start=1 End=2", samples[1])
+	}
+}
+
+func TestBitsPerSecond(t *testing.T) {
+	if got := bitsPerSecond(125_000, 1); got != 1_000_000 {
+		t.Errorf("bitsPerSecond(125000, 1) = %v, want 1000000", got)
+	}
+	if got := bitsPerSecond(1000, 0); got != 0 {
+		t.Errorf("bitsPerSecond(1000, 0) = %v, want 0", got)
+	}
+}
+
+func TestCPUPercent(t *testing.T) {
+	before := [2]time.Duration{0, 0}
+	after := [2]time.Duration{500 * time.Millisecond, 250 * time.Millisecond}
+	user, system, total := cpuPercent(before, after, time.Second)
+	if user != 50 {
+		t.Errorf("user = %v, want 50", user)
+	}
+	if system != 25 {
+		t.Errorf("system = %v, want 25", system)
+	}
+	if total != 75 {
+		t.Errorf("total = %v, want 75", total)
+	}
+
+	if user, system, total := cpuPercent(before, after, 0); user != 0 || system != 0 || total != 0 {
+		t.Errorf("cpuPercent with zero wall = (%v, %v, %v), want all zero", user, system, total)
+	}
+}
+
+func TestMergeResultForward(t *testing.T) {
+	cfg := Config{Duration: 10 * time.Second}
+	local := sideResult{Bytes: 1_250_000, Seconds: 10, Retransmits: 3}
+	remote := sideResult{Bytes: 1_000_000, Retransmits: 99, JitterMs: 1.5, LostPackets: 2, Packets: 100}
+
+	result := mergeResult(cfg, local, remote)
+	if result.SentBitsPerSecond != bitsPerSecond(local.Bytes, 10) {
+		t.Errorf("SentBitsPerSecond = %v, want local bytes converted", result.SentBitsPerSecond)
+	}
+	if result.ReceivedBitsPerSecond != bitsPerSecond(remote.Bytes, 10) {
+		t.Errorf("ReceivedBitsPerSecond = %v, want remote bytes converted", result.ReceivedBitsPerSecond)
+	}
+	if result.Retransmits != 3 {
+		t.Errorf("Retransmits = %v, want 3 (the sender's own count, not the receiver's)", result.Retransmits)
+	}
+	if result.JitterMs != 1.5 || result.LostPackets != 2 || result.Packets != 100 {
+		t.Errorf("forward mode should take jitter/loss from remote, got %+v", result)
+	}
+	if result.LostPercent != 2 {
+		t.Errorf("LostPercent = %v, want 2", result.LostPercent)
+	}
+}
+
+func TestMergeResultReverse(t *testing.T) {
+	cfg := Config{Duration: 10 * time.Second, Reverse: true}
+	local := sideResult{Bytes: 1_000_000, Seconds: 10, JitterMs: 0.8, LostPackets: 1, Packets: 50}
+	remote := sideResult{Bytes: 1_250_000, Retransmits: 7}
+
+	result := mergeResult(cfg, local, remote)
+	if result.ReceivedBitsPerSecond != bitsPerSecond(local.Bytes, 10) {
+		t.Errorf("ReceivedBitsPerSecond = %v, want local bytes converted", result.ReceivedBitsPerSecond)
+	}
+	if result.SentBitsPerSecond != bitsPerSecond(remote.Bytes, 10) {
+		t.Errorf("SentBitsPerSecond = %v, want remote bytes converted", result.SentBitsPerSecond)
+	}
+	if result.JitterMs != 0.8 || result.LostPackets != 1 || result.Packets != 50 {
+		t.Errorf("reverse mode should take jitter/loss from local, got %+v", result)
+	}
+}
@@ -0,0 +1,33 @@
+package iperf3
+
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// tcpRetransmits reads how many segments this end of conn has
+// retransmitted so far, via the same TCP_INFO socket option iperf3
+// itself reads (tcpi_total_retrans) on Linux. It's only meaningful on
+// the sending side of a TCP stream - the side that actually retransmits
+// - and returns 0 if conn isn't a TCP connection or the socket option
+// isn't available.
+func tcpRetransmits(conn net.Conn) int64 {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return 0
+	}
+	raw, err := tcpConn.SyscallConn()
+	if err != nil {
+		return 0
+	}
+	var retrans int64
+	_ = raw.Control(func(fd uintptr) {
+		info, err := unix.GetsockoptTCPInfo(int(fd), unix.IPPROTO_TCP, unix.TCP_INFO)
+		if err != nil {
+			return
+		}
+		retrans = int64(info.Total_retrans)
+	})
+	return retrans
+}
@@ -0,0 +1,46 @@
+package iperf3
+
+import (
+	"net"
+	"testing"
+)
+
+func TestTCPRetransmitsOnFreshConnection(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer ln.Close()
+
+	go func() {
+		c, err := ln.Accept()
+		if err == nil {
+			c.Close()
+		}
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	// A brand new, idle connection hasn't retransmitted anything; this
+	// mainly checks that the TCP_INFO lookup doesn't error out or panic
+	// on a real socket.
+	if got := tcpRetransmits(conn); got != 0 {
+		t.Errorf("tcpRetransmits() = %d, want 0 on a fresh connection", got)
+	}
+}
+
+func TestTCPRetransmitsNonTCPConn(t *testing.T) {
+	server, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenUDP: %v", err)
+	}
+	defer server.Close()
+
+	if got := tcpRetransmits(server); got != 0 {
+		t.Errorf("tcpRetransmits(udpConn) = %d, want 0", got)
+	}
+}
@@ -0,0 +1,158 @@
+package iperf3
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// udpHeaderSize is the size of the per-datagram header this client
+// writes at the start of every UDP packet. It matches iperf3's own
+// wire format (src/iperf_udp.c's struct UDP_datagram: a 32-bit id
+// followed by 32-bit tv_sec/tv_usec, all network byte order) so this
+// client interoperates with a standard iperf3 server/client instead of
+// just itself; a real server needs these fields to compute the jitter
+// and loss it reports back to us in forward mode, and we need them to
+// compute our own in reverse mode.
+//
+// One piece of the real protocol this does not implement: iperf3 marks
+// the very last datagram of a stream by negating its id, so the peer
+// can tell a real loss apart from "the test just ended". We instead
+// rely on the test's own duration/deadline to know when to stop
+// counting, which slightly undercounts loss right at the boundary but
+// does not corrupt jitter or mid-test loss figures.
+const udpHeaderSize = 12
+
+// udpStats accumulates jitter and loss across however many parallel UDP
+// streams a test uses. Packets arrive interleaved from multiple
+// goroutines, so every update is taken under mu.
+type udpStats struct {
+	mu          sync.Mutex
+	haveSeq     bool
+	firstSeq    uint32
+	highestSeq  uint32
+	lastTransit float64
+	jitter      float64
+	packets     int64
+}
+
+// onPacket folds one received datagram into the running jitter/loss
+// estimate, using the same exponential moving average RFC 1889 (and
+// iperf3) use: jitter grows by 1/16th of how far this packet's transit
+// time deviated from the last one's.
+func (s *udpStats) onPacket(seq uint32, sent time.Time, now time.Time) {
+	transit := now.Sub(sent).Seconds()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.packets > 0 {
+		delta := transit - s.lastTransit
+		if delta < 0 {
+			delta = -delta
+		}
+		s.jitter += (delta - s.jitter) / 16
+	}
+	s.lastTransit = transit
+	s.packets++
+	if !s.haveSeq {
+		s.firstSeq = seq
+		s.highestSeq = seq
+		s.haveSeq = true
+	} else if seq > s.highestSeq {
+		s.highestSeq = seq
+	}
+}
+
+// result reports the jitter, in milliseconds, and the number of packets
+// lost, computed as the gap between the highest sequence number seen
+// and how many packets actually arrived.
+func (s *udpStats) result() (jitterMs float64, lost int64, packets int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	packets = s.packets
+	if s.haveSeq {
+		expected := int64(s.highestSeq) - int64(s.firstSeq) + 1
+		if expected > packets {
+			lost = expected - packets
+		}
+	}
+	return s.jitter * 1000, lost, packets
+}
+
+// defaultUDPBandwidth is iperf3's own default target rate for UDP tests
+// when -b/--bandwidth is left unset.
+const defaultUDPBandwidth = 1_000_000
+
+// udpSend paces writes of bufSize datagrams to conn so the aggregate
+// send rate across all of a test's streams matches cfg.Bandwidth,
+// stamping each packet with a sequence number and send time so the
+// receiver can compute jitter and loss. It runs until ctx is done.
+func udpSend(ctx context.Context, conn net.Conn, bufSize int, bandwidthPerStream uint64, counter *atomic.Int64) {
+	if bandwidthPerStream == 0 {
+		bandwidthPerStream = defaultUDPBandwidth
+	}
+	buf := make([]byte, bufSize)
+	var seq uint32
+	start := time.Now()
+	var sent int64
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+		seq++
+		now := time.Now()
+		binary.BigEndian.PutUint32(buf[0:4], seq)
+		binary.BigEndian.PutUint32(buf[4:8], uint32(now.Unix()))
+		binary.BigEndian.PutUint32(buf[8:12], uint32(now.Nanosecond()/1000))
+		if _, err := conn.Write(buf); err != nil {
+			return
+		}
+		sent += int64(bufSize)
+		counter.Add(int64(bufSize))
+
+		targetElapsed := time.Duration(float64(sent) * 8 / float64(bandwidthPerStream) * float64(time.Second))
+		if actual := time.Since(start); actual < targetElapsed {
+			select {
+			case <-time.After(targetElapsed - actual):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// udpReceive reads bufSize datagrams from conn until ctx is done,
+// folding each one's sequence number and send timestamp into stats and
+// its payload size into counter.
+func udpReceive(ctx context.Context, conn net.Conn, bufSize int, counter *atomic.Int64, stats *udpStats) {
+	buf := make([]byte, bufSize)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+		conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		n, err := conn.Read(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			return
+		}
+		counter.Add(int64(n))
+		if n < udpHeaderSize {
+			continue
+		}
+		seq := binary.BigEndian.Uint32(buf[0:4])
+		sec := binary.BigEndian.Uint32(buf[4:8])
+		usec := binary.BigEndian.Uint32(buf[8:12])
+		sent := time.Unix(int64(sec), int64(usec)*1000)
+		stats.onPacket(seq, sent, time.Now())
+	}
+}
@@ -1,65 +1,30 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
-	"errors"
+	"context"
 	"flag"
-	"fmt"
 	"log"
 	"net/http"
-	"os/exec"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
-)
-
-type IPerf3Summary struct {
-	Start         float32 `json:"start"`
-	End           float32 `json:"end"`
-	Seconds       float32 `json:"seconds"`
-	Bytes         int     `json:"bytes"`
-	BitsPerSecond float64 `json:"bits_per_second"`
-	Retransmits   float64 `json:"retransmits"`
-}
 
-type IPerf3Result struct {
-	Error string `json:"error"`
-	End   struct {
-		SumSent     IPerf3Summary `json:"sum_sent"`
-		SumReceived IPerf3Summary `json:"sum_received"`
-	} `json:"end"`
-}
-
-func ExecIPerf3(server, port string) (*IPerf3Result, error) {
-	stdout := bytes.NewBuffer(nil)
-	cmd := exec.Command("iperf3", "--json", "-c", server, "-p", port, "--connect-timeout", "1000")
-	cmd.Stdout = stdout
-	result := &IPerf3Result{}
-	err := cmd.Run()
-	if err != nil {
-		return nil, err
-	}
-	exitCode := cmd.ProcessState.ExitCode()
-	if exitCode != 0 {
-		return nil, fmt.Errorf("exit code: %d", exitCode)
-	}
-	err = json.Unmarshal(stdout.Bytes(), result)
-	if err != nil {
-		return nil, err
-	}
-	if result.Error != "" {
-		return nil, errors.New(result.Error)
-	}
-	return result, err
-}
+	"github.com/gofly/iperf_exporter/iperf3"
+)
 
 func main() {
 	server := flag.String("server", "127.0.0.1", "iperf3 server ip")
 	port := flag.String("port", "5201", "iperf3 server port")
 	interval := flag.String("interval", "5m", "iperf3 execute interval")
 	addr := flag.String("addr", ":9103", "exporter addr")
+	configFile := flag.String("config.file", "", "path to a YAML file describing multiple iperf3 targets and/or /probe modules; overrides -server/-port")
+	disableLoop := flag.Bool("loop.disable", false, "disable the background scrape loop and only serve /probe")
+	probeTimeoutFlag := flag.Duration("probe.timeout", 10*time.Second, "default /probe deadline, used when Prometheus does not send X-Prometheus-Scrape-Timeout-Seconds")
+	udp := flag.Bool("udp", false, "run a UDP test instead of TCP (single-target mode only)")
+	bandwidth := flag.String("bandwidth", "", "target bandwidth, e.g. 10M (required for -udp; single-target mode only)")
+	length := flag.Int("length", 0, "read/write buffer length in bytes, 0 uses the protocol default (single-target mode only)")
+	testTime := flag.String("time", "10s", "iperf3 test duration (single-target mode only)")
 	flag.Parse()
 
 	execInterval, err := time.ParseDuration(*interval)
@@ -67,53 +32,179 @@ func main() {
 		log.Fatal("[FATAL] invalid interval, ", err)
 	}
 
-	errorCount := prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Namespace: "network",
-		Subsystem: "iperf3",
-		Name:      "error_count",
-	}, []string{"server"})
-	sentBitPerSec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Namespace: "network",
-		Subsystem: "iperf3",
-		Name:      "sent_bits_per_second",
-	}, []string{"server"})
-	sentRetransmits := prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Namespace: "network",
-		Subsystem: "iperf3",
-		Name:      "sent_retransmits",
-	}, []string{"server"})
-	receivedBitPerSec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Namespace: "network",
-		Subsystem: "iperf3",
-		Name:      "received_bits_per_second",
-	}, []string{"server"})
-	receivedRetransmits := prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Namespace: "network",
-		Subsystem: "iperf3",
-		Name:      "received_retransmits",
-	}, []string{"server"})
-	go func() {
-		for {
-			result, err := ExecIPerf3(*server, *port)
+	var cfg *Config
+	if *configFile != "" {
+		cfg, err = LoadConfig(*configFile)
+		if err != nil {
+			log.Fatal("[FATAL] load config file, ", err)
+		}
+	} else {
+		protocol := "tcp"
+		if *udp {
+			protocol = "udp"
+		}
+		cfg = &Config{
+			Concurrency: 1,
+			Targets: []TargetConfig{
+				{
+					Address:   *server,
+					Port:      *port,
+					Protocol:  protocol,
+					Bandwidth: *bandwidth,
+					Duration:  *testTime,
+					Length:    *length,
+					Parallel:  1,
+				},
+			},
+		}
+		cfg.initSemaphore()
+	}
+
+	if !*disableLoop {
+		labelNames := append([]string{"target"}, cfg.labelKeys()...)
+
+		errorCount := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "network",
+			Subsystem: "iperf3",
+			Name:      "error_count",
+		}, labelNames)
+		sentBitPerSec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "network",
+			Subsystem: "iperf3",
+			Name:      "sent_bits_per_second",
+		}, labelNames)
+		sentRetransmits := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "network",
+			Subsystem: "iperf3",
+			Name:      "sent_retransmits",
+		}, labelNames)
+		receivedBitPerSec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "network",
+			Subsystem: "iperf3",
+			Name:      "received_bits_per_second",
+		}, labelNames)
+		jitterMilliseconds := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "network",
+			Subsystem: "iperf3",
+			Name:      "jitter_milliseconds",
+		}, labelNames)
+		lostPacketsTotal := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "network",
+			Subsystem: "iperf3",
+			Name:      "lost_packets_total",
+		}, labelNames)
+		lostPercent := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "network",
+			Subsystem: "iperf3",
+			Name:      "lost_percent",
+		}, labelNames)
+		lastRunTimestamp := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "network",
+			Subsystem: "iperf3",
+			Name:      "last_run_timestamp_seconds",
+		}, labelNames)
+		cpuUtilizationPercent := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "network",
+			Subsystem: "iperf3",
+			Name:      "cpu_utilization_percent",
+		}, append(append([]string{}, labelNames...), "host", "type"))
+		intervalBitPerSec := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "network",
+			Subsystem: "iperf3",
+			Name:      "interval_bits_per_second",
+			Buckets:   prometheus.ExponentialBuckets(1e6, 2, 14),
+		}, append(append([]string{}, labelNames...), "direction"))
+
+		// Global concurrency limit: two iperf3 clients can't share the
+		// same server socket, so probes are run through a bounded worker
+		// pool. The pool lives on cfg and is shared with probeHandler, so
+		// a /probe scrape and the background loop can't collide against
+		// the same target either. Each target already runs sequentially
+		// within its own goroutine (the loop body blocks on iperf3.Run
+		// before starting the next run), so no additional per-target lock
+		// is needed here.
+		for i := range cfg.Targets {
+			target := cfg.Targets[i]
+			iperf3Cfg, err := target.iperf3Config()
 			if err != nil {
-				log.Println("[ERROR] execute iperf3 with error:", err)
-				errorCount.WithLabelValues(*server).Add(1)
-				sentBitPerSec.Reset()
-				sentRetransmits.Reset()
-				receivedBitPerSec.Reset()
-				receivedRetransmits.Reset()
-				time.Sleep(time.Second * 10)
-				continue
+				log.Fatal("[FATAL] invalid target config, ", err)
+			}
+			labelValues := make([]string, len(labelNames))
+			labelValues[0] = target.Address
+			for j, name := range labelNames[1:] {
+				labelValues[j+1] = target.Labels[name]
 			}
-			sentBitPerSec.WithLabelValues(*server).Set(result.End.SumSent.BitsPerSecond)
-			sentRetransmits.WithLabelValues(*server).Set(result.End.SumSent.Retransmits)
-			receivedBitPerSec.WithLabelValues(*server).Set(result.End.SumReceived.BitsPerSecond)
-			receivedRetransmits.WithLabelValues(*server).Set(result.End.SumReceived.Retransmits)
-			time.Sleep(execInterval)
+
+			go func() {
+				// runTimeout bounds a single run at the test's own duration
+				// plus a fixed margin for the handshake and result
+				// exchange, the same margin probeTimeout leaves in
+				// probe.go, so a wedged server can't hang this goroutine
+				// forever.
+				runTimeout := iperf3Cfg.Duration + 10*time.Second
+				for {
+					cfg.acquire()
+					ctx, cancel := context.WithTimeout(context.Background(), runTimeout)
+					result, err := iperf3.Run(ctx, iperf3Cfg)
+					cancel()
+					cfg.release()
+					if err != nil {
+						log.Println("[ERROR] run iperf3 with error:", err)
+						errorCount.WithLabelValues(labelValues...).Add(1)
+						// Clear every gauge this target can publish so a
+						// failed run doesn't leave the previous success's
+						// values stuck on the series forever. last_run_timestamp_seconds
+						// is deliberately left alone: it tracks the last
+						// successful run, not the last attempt.
+						sentBitPerSec.DeleteLabelValues(labelValues...)
+						sentRetransmits.DeleteLabelValues(labelValues...)
+						receivedBitPerSec.DeleteLabelValues(labelValues...)
+						jitterMilliseconds.DeleteLabelValues(labelValues...)
+						lostPacketsTotal.DeleteLabelValues(labelValues...)
+						lostPercent.DeleteLabelValues(labelValues...)
+						for _, host := range []string{"local", "remote"} {
+							for _, typ := range []string{"user", "system", "total"} {
+								cpuUtilizationPercent.DeleteLabelValues(append(append([]string{}, labelValues...), host, typ)...)
+							}
+						}
+						time.Sleep(time.Second * 10)
+						continue
+					}
+					sentBitPerSec.WithLabelValues(labelValues...).Set(result.SentBitsPerSecond)
+					sentRetransmits.WithLabelValues(labelValues...).Set(float64(result.Retransmits))
+					receivedBitPerSec.WithLabelValues(labelValues...).Set(result.ReceivedBitsPerSecond)
+					jitterMilliseconds.WithLabelValues(labelValues...).Set(result.JitterMs)
+					lostPacketsTotal.WithLabelValues(labelValues...).Set(float64(result.LostPackets))
+					lostPercent.WithLabelValues(labelValues...).Set(result.LostPercent)
+					lastRunTimestamp.WithLabelValues(labelValues...).Set(float64(time.Now().Unix()))
+					for _, host := range []struct {
+						label string
+						cpu   iperf3.CPUUtilization
+					}{{"local", result.LocalCPU}, {"remote", result.RemoteCPU}} {
+						cpuUtilizationPercent.WithLabelValues(append(append([]string{}, labelValues...), host.label, "user")...).Set(host.cpu.User)
+						cpuUtilizationPercent.WithLabelValues(append(append([]string{}, labelValues...), host.label, "system")...).Set(host.cpu.System)
+						cpuUtilizationPercent.WithLabelValues(append(append([]string{}, labelValues...), host.label, "total")...).Set(host.cpu.Total)
+					}
+					for _, sample := range result.SentIntervals {
+						intervalBitPerSec.WithLabelValues(append(append([]string{}, labelValues...), "sent")...).Observe(sample.BitsPerSecond)
+					}
+					for _, sample := range result.ReceivedIntervals {
+						intervalBitPerSec.WithLabelValues(append(append([]string{}, labelValues...), "received")...).Observe(sample.BitsPerSecond)
+					}
+					time.Sleep(execInterval)
+				}
+			}()
 		}
-	}()
-	prometheus.MustRegister(sentBitPerSec, sentRetransmits, receivedBitPerSec, receivedRetransmits)
-	http.Handle("/metrics", promhttp.Handler())
+
+		prometheus.MustRegister(
+			sentBitPerSec, sentRetransmits, receivedBitPerSec,
+			jitterMilliseconds, lostPacketsTotal, lostPercent, lastRunTimestamp,
+			cpuUtilizationPercent, intervalBitPerSec,
+		)
+		http.Handle("/metrics", promhttp.Handler())
+	}
+
+	http.Handle("/probe", probeHandler(cfg, *probeTimeoutFlag))
 	err = http.ListenAndServe(*addr, nil)
 	if err != nil {
 		log.Fatal("[FATAL] start http server fatal:", err)
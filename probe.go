@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/gofly/iperf_exporter/iperf3"
+)
+
+// probeHandler implements a blackbox_exporter-style /probe endpoint:
+// given ?target=host:port&module=name it runs a single, synchronous
+// iperf3 test and returns the result as a fresh set of metrics, separate
+// from the /metrics registry used by the background scrape loop. It
+// acquires cfg's shared run pool before calling iperf3.Run, the same
+// pool the background loop uses, so a probe can't collide with a
+// concurrent run against the same target.
+func probeHandler(cfg *Config, defaultTimeout time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		target := r.URL.Query().Get("target")
+		if target == "" {
+			http.Error(w, "target parameter is required", http.StatusBadRequest)
+			return
+		}
+		moduleName := r.URL.Query().Get("module")
+		module, ok := cfg.Modules[moduleName]
+		if !ok {
+			http.Error(w, fmt.Sprintf("unknown module %q", moduleName), http.StatusBadRequest)
+			return
+		}
+		address, port, err := net.SplitHostPort(target)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid target %q: %s", target, err), http.StatusBadRequest)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), probeTimeout(r, defaultTimeout))
+		defer cancel()
+
+		registry := prometheus.NewRegistry()
+		success := prometheus.NewGauge(prometheus.GaugeOpts{Subsystem: "iperf3", Name: "success", Help: "Whether the iperf3 probe succeeded."})
+		duration := prometheus.NewGauge(prometheus.GaugeOpts{Subsystem: "iperf3", Name: "duration_seconds", Help: "Total time taken for the iperf3 probe."})
+		sentBitPerSec := prometheus.NewGauge(prometheus.GaugeOpts{Subsystem: "iperf3", Name: "sent_bits_per_second", Help: "Sent throughput in bits per second."})
+		receivedBitPerSec := prometheus.NewGauge(prometheus.GaugeOpts{Subsystem: "iperf3", Name: "received_bits_per_second", Help: "Received throughput in bits per second."})
+		retransmits := prometheus.NewGauge(prometheus.GaugeOpts{Subsystem: "iperf3", Name: "retransmits", Help: "Number of TCP retransmits."})
+		jitter := prometheus.NewGauge(prometheus.GaugeOpts{Subsystem: "iperf3", Name: "jitter_milliseconds", Help: "UDP jitter in milliseconds."})
+		lostPackets := prometheus.NewGauge(prometheus.GaugeOpts{Subsystem: "iperf3", Name: "lost_packets", Help: "Number of lost UDP packets."})
+		lostPercent := prometheus.NewGauge(prometheus.GaugeOpts{Subsystem: "iperf3", Name: "lost_percent", Help: "Percentage of lost UDP packets."})
+		registry.MustRegister(success, duration, sentBitPerSec, receivedBitPerSec, retransmits, jitter, lostPackets, lostPercent)
+
+		iperf3Cfg, err := module.targetConfig(address, port).iperf3Config()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid module %q: %s", moduleName, err), http.StatusBadRequest)
+			return
+		}
+
+		cfg.acquire()
+		start := time.Now()
+		result, err := iperf3.Run(ctx, iperf3Cfg)
+		duration.Set(time.Since(start).Seconds())
+		cfg.release()
+		if err != nil {
+			log.Println("[ERROR] probe", target, "module", moduleName, "failed:", err)
+			success.Set(0)
+		} else {
+			success.Set(1)
+			sentBitPerSec.Set(result.SentBitsPerSecond)
+			receivedBitPerSec.Set(result.ReceivedBitsPerSecond)
+			retransmits.Set(float64(result.Retransmits))
+			jitter.Set(result.JitterMs)
+			lostPackets.Set(float64(result.LostPackets))
+			lostPercent.Set(result.LostPercent)
+		}
+
+		promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+	}
+}
+
+// probeTimeout derives the probe deadline from Prometheus's scrape
+// timeout header, leaving a small margin so the response always makes
+// it back before Prometheus gives up. It falls back to fallback when
+// the header is absent or invalid.
+func probeTimeout(r *http.Request, fallback time.Duration) time.Duration {
+	v := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds")
+	if v == "" {
+		return fallback
+	}
+	seconds, err := strconv.ParseFloat(v, 64)
+	if err != nil || seconds <= 0 {
+		return fallback
+	}
+	timeout := time.Duration(seconds * float64(time.Second))
+	if timeout > 500*time.Millisecond {
+		timeout -= 500 * time.Millisecond
+	}
+	return timeout
+}
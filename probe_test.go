@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestProbeTimeout(t *testing.T) {
+	const fallback = 10 * time.Second
+
+	tests := []struct {
+		name   string
+		header string
+		want   time.Duration
+	}{
+		{name: "no header", header: "", want: fallback},
+		{name: "invalid header", header: "not-a-number", want: fallback},
+		{name: "zero header", header: "0", want: fallback},
+		{name: "5 second header leaves margin", header: "5", want: 5*time.Second - 500*time.Millisecond},
+		{name: "sub-margin header is returned as-is", header: "0.2", want: 200 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/probe", nil)
+			if tt.header != "" {
+				r.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", tt.header)
+			}
+			if got := probeTimeout(r, fallback); got != tt.want {
+				t.Errorf("probeTimeout() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}